@@ -0,0 +1,46 @@
+// Package gitcmd runs git subcommands with a consistent, testable
+// interface: both stdout and stderr captured, a per-call timeout, and a
+// non-interactive, locale-stable environment.
+package gitcmd
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// Runner executes git commands. The zero value is usable and runs with no
+// extra timeout and no configured SSH key.
+type Runner struct {
+	// Timeout bounds each Run call, in addition to whatever deadline ctx
+	// already carries. Zero means don't add one.
+	Timeout time.Duration
+	// SSHKeyFile, when set, is passed to git via GIT_SSH_COMMAND so ssh
+	// remotes authenticate with that key instead of the default identity.
+	SSHKeyFile string
+}
+
+// Run executes `git args...` in dir (the current directory, if dir is
+// empty), returning its captured stdout and stderr. The command runs
+// non-interactively (GIT_TERMINAL_PROMPT=0) with a locale-stable
+// environment (LC_ALL=C) so callers can parse output reliably.
+func (r Runner) Run(ctx context.Context, dir string, args ...string) (stdout, stderr string, err error) {
+	if r.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.Timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "LC_ALL=C")
+	if r.SSHKeyFile != "" {
+		cmd.Env = append(cmd.Env, "GIT_SSH_COMMAND=ssh -i "+r.SSHKeyFile+" -o IdentitiesOnly=yes")
+	}
+	var stdoutBuf, stderrBuf bytes.Buffer
+	cmd.Stdout = &stdoutBuf
+	cmd.Stderr = &stderrBuf
+	err = cmd.Run()
+	return stdoutBuf.String(), stderrBuf.String(), err
+}
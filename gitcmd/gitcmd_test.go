@@ -0,0 +1,87 @@
+package gitcmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFakeGit installs a shell script named "git" on a temporary PATH
+// that echoes its arguments and the environment variables Run is
+// supposed to set, then exits 1 (printing "boom" to stderr) when its
+// first argument is "fail". It returns a cleanup func that restores PATH.
+func writeFakeGit(t *testing.T) func() {
+	t.Helper()
+	dir := t.TempDir()
+	script := `#!/bin/sh
+echo "ARGS:$@"
+echo "GIT_TERMINAL_PROMPT=$GIT_TERMINAL_PROMPT"
+echo "LC_ALL=$LC_ALL"
+echo "GIT_SSH_COMMAND=$GIT_SSH_COMMAND"
+if [ "$1" = "fail" ]; then
+	echo "boom" >&2
+	exit 1
+fi
+exit 0
+`
+	path := filepath.Join(dir, "git")
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	oldPath := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+oldPath)
+	return func() { os.Setenv("PATH", oldPath) }
+}
+
+func TestRunnerRun(t *testing.T) {
+	restore := writeFakeGit(t)
+	defer restore()
+
+	tests := []struct {
+		name       string
+		runner     Runner
+		args       []string
+		wantStdout []string
+		wantErr    bool
+		wantStderr string
+	}{
+		{
+			name:       "plain command sets a locale-stable, non-interactive environment",
+			runner:     Runner{},
+			args:       []string{"status"},
+			wantStdout: []string{"ARGS:status", "GIT_TERMINAL_PROMPT=0", "LC_ALL=C", "GIT_SSH_COMMAND="},
+		},
+		{
+			name:       "SSHKeyFile sets GIT_SSH_COMMAND",
+			runner:     Runner{SSHKeyFile: "/home/user/.ssh/id_ed25519"},
+			args:       []string{"fetch"},
+			wantStdout: []string{"GIT_SSH_COMMAND=ssh -i /home/user/.ssh/id_ed25519 -o IdentitiesOnly=yes"},
+		},
+		{
+			name:       "failing command returns stderr and an error",
+			runner:     Runner{},
+			args:       []string{"fail"},
+			wantErr:    true,
+			wantStderr: "boom\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stdout, stderr, err := tt.runner.Run(context.Background(), "", tt.args...)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Run() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			for _, want := range tt.wantStdout {
+				if !strings.Contains(stdout, want) {
+					t.Errorf("stdout = %q, want substring %q", stdout, want)
+				}
+			}
+			if tt.wantStderr != "" && stderr != tt.wantStderr {
+				t.Errorf("stderr = %q, want %q", stderr, tt.wantStderr)
+			}
+		})
+	}
+}
@@ -1,47 +1,134 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"regexp"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/jinzhao1994/code-manager/gitcmd"
 	"github.com/jinzhao1994/glog"
 	"gopkg.in/ini.v1"
 	"gopkg.in/yaml.v2"
 )
 
+// RepoConfig describes one tracked repository. Remote is kept alongside
+// Remotes for backward compatibility with config files written before
+// multi-remote support; it always mirrors Remotes["origin"].
 type RepoConfig struct {
 	Directory string
-	Remote    string
+	Remote    string            `yaml:"Remote,omitempty"`
+	Remotes   map[string]string `yaml:"Remotes,omitempty"`
+	Branch    string            `yaml:"Branch,omitempty"`
+
+	// Mirror, when set, is a second remote URL that -mirror pushes every
+	// ref to after a successful fetch.
+	Mirror            string    `yaml:"Mirror,omitempty"`
+	MirrorLastSuccess time.Time `yaml:"MirrorLastSuccess,omitempty"`
+}
+
+// UnmarshalYAML fills in Remotes from a legacy Remote-only entry, and vice
+// versa, so old and new repositories.txt files both load cleanly.
+func (r *RepoConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type rawRepoConfig RepoConfig
+	var raw rawRepoConfig
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+	*r = RepoConfig(raw)
+	if len(r.Remotes) == 0 && r.Remote != "" {
+		r.Remotes = map[string]string{"origin": r.Remote}
+	}
+	if r.Remote == "" {
+		r.Remote = r.Remotes["origin"]
+	}
+	return nil
 }
 
 type Config struct {
 	Repos []RepoConfig
+	// Ignore is a list of gitignore-style globs, matched against both the
+	// full directory path and its base name, applied while walking RootDir
+	// so matching subtrees are never descended into.
+	Ignore []string `yaml:"Ignore,omitempty"`
+	// Groups maps a group name to a list of globs matched against
+	// RepoConfig.Directory, consulted by -only to select a subset of Repos.
+	Groups map[string][]string `yaml:"Groups,omitempty"`
+	// Auth supplies per-remote credentials, matched by the longest
+	// URLPrefix. The go-git backend uses every Type; the git backend uses
+	// only an ssh entry's KeyFile (via GIT_SSH_COMMAND) and otherwise
+	// relies on the git binary's own configuration.
+	Auth []AuthConfig `yaml:"Auth,omitempty"`
+}
+
+// AuthConfig describes how to authenticate against remotes whose URL
+// starts with URLPrefix.
+type AuthConfig struct {
+	URLPrefix string
+	Type      string // "ssh", "https", or "token"
+	KeyFile   string `yaml:"KeyFile,omitempty"`
+	TokenEnv  string `yaml:"TokenEnv,omitempty"`
 }
 
 type Flag struct {
-	RootDir string
-	Update  bool
-	Upgrade bool
+	RootDir  string
+	Update   bool
+	Upgrade  bool
+	Jobs     int
+	Timeout  time.Duration
+	Backend  string
+	Mirror   bool
+	HTTP     string
+	Interval time.Duration
+	Only     string
+	Exclude  string
 }
 
 var config Config
 var flags Flag
+var vcs VCS
 
 func init() {
 	flag.StringVar(&flags.RootDir, "dir", "/Volumes/Code/src", "root dir to check")
 	flag.BoolVar(&flags.Update, "update", true, "update config file")
 	flag.BoolVar(&flags.Upgrade, "upgrade", true, "upgrade repositories")
+	flag.IntVar(&flags.Jobs, "jobs", 4, "number of repositories to process concurrently during upgrade")
+	flag.DurationVar(&flags.Timeout, "timeout", 5*time.Minute, "timeout for each git operation")
+	flag.StringVar(&flags.Backend, "backend", "git", "VCS backend to use: \"git\" or \"go-git\"")
+	flag.BoolVar(&flags.Mirror, "mirror", false, "push repositories with a Mirror remote configured to that remote after fetching")
+	flag.StringVar(&flags.HTTP, "http", "", "address to serve a status page and Prometheus metrics on (e.g. :8080); runs update/upgrade on -interval forever instead of once")
+	flag.DurationVar(&flags.Interval, "interval", time.Hour, "how often to re-run update/upgrade when -http is set")
+	flag.StringVar(&flags.Only, "only", "", "comma-separated list of Config.Groups to restrict update/upgrade to")
+	flag.StringVar(&flags.Exclude, "exclude", "", "glob pattern; matching directories are excluded from update/upgrade")
+}
+
+// gitRunner builds a gitcmd.Runner for remoteURL, picking up a configured
+// SSH key from Config.Auth (if any) so clones/fetches/pulls against that
+// remote authenticate the same way the go-git backend does.
+func gitRunner(remoteURL string) gitcmd.Runner {
+	r := gitcmd.Runner{Timeout: flags.Timeout}
+	var best *AuthConfig
+	for i := range config.Auth {
+		a := &config.Auth[i]
+		if strings.HasPrefix(remoteURL, a.URLPrefix) && (best == nil || len(a.URLPrefix) > len(best.URLPrefix)) {
+			best = a
+		}
+	}
+	if best != nil && best.Type == "ssh" && best.KeyFile != "" {
+		r.SSHKeyFile = best.KeyFile
+	}
+	return r
 }
 
-func gitClone(repo RepoConfig) (string, error) {
+func gitClone(ctx context.Context, repo RepoConfig) (string, error) {
 	_, err := os.Stat(filepath.Join(repo.Directory, ".git"))
 	if err == nil {
 		return "", filepath.SkipDir
@@ -49,92 +136,161 @@ func gitClone(repo RepoConfig) (string, error) {
 	if !os.IsNotExist(err) {
 		return "", err
 	}
-	cmd := exec.Command("git", "clone", repo.Remote, repo.Directory)
-	stderr, err := cmd.StderrPipe()
-	if err != nil {
-		return "", err
-	}
-	if err := cmd.Start(); err != nil {
-		return "", err
-	}
-	stderrBytes, err := ioutil.ReadAll(stderr)
+	_, stderr, err := gitRunner(repo.Remote).Run(ctx, "", "clone", repo.Remote, repo.Directory)
+	return stderr, err
+}
+
+func gitFetch(ctx context.Context, repo RepoConfig) (string, error) {
+	_, stderr, err := gitRunner(repo.Remote).Run(ctx, repo.Directory, "fetch")
+	return stderr, err
+}
+
+// gitOutput runs a read-only git subcommand in dir and returns its
+// trimmed stdout.
+func gitOutput(ctx context.Context, dir string, args ...string) (string, error) {
+	stdout, _, err := (gitcmd.Runner{Timeout: flags.Timeout}).Run(ctx, dir, args...)
 	if err != nil {
 		return "", err
 	}
-	if err := cmd.Wait(); err != nil {
-		return string(stderrBytes), err
-	}
-	return string(stderrBytes), nil
+	return strings.TrimSpace(stdout), nil
 }
 
-func gitFetch(repo RepoConfig) (string, error) {
-	cmd := exec.Command("git", "fetch")
-	cmd.Dir = repo.Directory
-	stderr, err := cmd.StderrPipe()
+// aheadBehind runs `git rev-list --left-right --count HEAD...upstream` in
+// dir and parses the two counts it prints.
+func aheadBehind(ctx context.Context, dir, upstream string) (ahead, behind int, err error) {
+	counts, err := gitOutput(ctx, dir, "rev-list", "--left-right", "--count", "HEAD..."+upstream)
 	if err != nil {
-		return "", err
+		return 0, 0, err
 	}
-	if err := cmd.Start(); err != nil {
-		return "", err
+	parts := strings.Fields(counts)
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("unexpected rev-list output %q", counts)
 	}
-	stderrBytes, err := ioutil.ReadAll(stderr)
+	ahead, err = strconv.Atoi(parts[0])
 	if err != nil {
-		return "", err
+		return 0, 0, err
 	}
-	if err := cmd.Wait(); err != nil {
-		return string(stderrBytes), err
+	behind, err = strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, err
 	}
-	return string(stderrBytes), nil
+	return ahead, behind, nil
 }
 
-const gitStatusTemplateStr = `^On branch master
-(Your branch is behind 'origin/master' by \d+ commit(s?), and can be fast-forwarded\.|Your branch is up-to-date with 'origin/master'\.)
-(  \(use "git pull" to update your local branch\)\n)?
-nothing to commit, working tree clean
-$`
-
-var gitStatusTemplateRe = regexp.MustCompile(gitStatusTemplateStr)
-
-func gitPull(repo RepoConfig) (string, error) {
-	// Check if can pull
-	cmd := exec.Command("git", "status")
-	cmd.Dir = repo.Directory
-	stdout, err := cmd.StdoutPipe()
+func gitPull(ctx context.Context, repo RepoConfig) (string, error) {
+	// A dirty worktree is never safe to fast-forward.
+	status, err := gitOutput(ctx, repo.Directory, "status", "--porcelain")
 	if err != nil {
 		return "", err
 	}
-	if err := cmd.Start(); err != nil {
-		return "", err
+	if status != "" {
+		return "", filepath.SkipDir
 	}
-	stdoutBytes, err := ioutil.ReadAll(stdout)
+	// Resolve the current branch and, when RepoConfig.Branch is set, make
+	// sure we're tracking the branch the caller asked for. A failure here
+	// (e.g. detached HEAD) is a real problem, not a benign skip.
+	branch, err := gitOutput(ctx, repo.Directory, "symbolic-ref", "--short", "HEAD")
 	if err != nil {
 		return "", err
 	}
-	if err := cmd.Wait(); err != nil {
-		return "", err
+	if repo.Branch != "" && branch != repo.Branch {
+		return "", filepath.SkipDir
 	}
-	// Skip this dir
-	if !gitStatusTemplateRe.Match(stdoutBytes) {
+	// Resolve the upstream; no upstream configured is the one benign case
+	// worth folding into a skip rather than a failure.
+	upstream, err := gitOutput(ctx, repo.Directory, "rev-parse", "--abbrev-ref", "@{u}")
+	if err != nil {
 		return "", filepath.SkipDir
 	}
-	// Run git pull
-	cmd = exec.Command("git", "pull")
-	cmd.Dir = repo.Directory
-	stderr, err := cmd.StderrPipe()
+	// A diverged branch (ahead and behind at once) isn't safe to fast-forward
+	// either; only pull when HEAD is strictly behind.
+	ahead, behind, err := aheadBehind(ctx, repo.Directory, upstream)
 	if err != nil {
 		return "", err
 	}
-	if err := cmd.Start(); err != nil {
-		return "", err
+	if ahead > 0 || behind == 0 {
+		return "", filepath.SkipDir
 	}
-	stderrBytes, err := ioutil.ReadAll(stderr)
-	if err != nil {
-		return "", err
+	// Run git pull
+	_, stderr, err := gitRunner(repo.Remote).Run(ctx, repo.Directory, "pull")
+	return stderr, err
+}
+
+// upgradeSummary records the outcome of processing one repository; upgrade()
+// tallies them so a single aggregate report can be logged once the worker
+// pool drains,
+// instead of forcing the reader to reconstruct it from interleaved lines.
+type upgradeSummary struct {
+	Directory string
+	Cloned    bool
+	Fetched   bool
+	Pulled    bool
+	Skipped   bool
+	Failed    bool
+}
+
+// upgradeRepo runs clone/fetch/pull for a single repository and reports
+// what happened. It never returns an error itself; failures are recorded
+// on the returned summary so the caller can keep processing other repos.
+func upgradeRepo(ctx context.Context, repo RepoConfig) upgradeSummary {
+	result := upgradeSummary{Directory: repo.Directory}
+
+	cloneCtx, cloneCancel := context.WithTimeout(ctx, flags.Timeout)
+	cloneStart := time.Now()
+	cloneErr := vcs.Clone(cloneCtx, repo)
+	recordPhaseDuration("clone", time.Since(cloneStart))
+	cloneCancel()
+	if cloneErr == filepath.SkipDir {
+		// No log for exist repos
+	} else if cloneErr != nil {
+		glog.Errorf("Clone to %s failed: %v", repo.Directory, cloneErr)
+		result.Failed = true
+		recordFailure(repo.Directory)
+		recordStatus(repo, result, 0, 0)
+		return result
+	} else {
+		glog.Infof("Clone to %s finished", repo.Directory)
+		result.Cloned = true
 	}
-	if err := cmd.Wait(); err != nil {
-		return string(stderrBytes), err
+
+	fetchCtx, fetchCancel := context.WithTimeout(ctx, flags.Timeout)
+	fetchStart := time.Now()
+	fetchErr := vcs.Fetch(fetchCtx, repo)
+	recordPhaseDuration("fetch", time.Since(fetchStart))
+	fetchCancel()
+	if fetchErr != nil {
+		glog.Errorf("Fetch in %s failed: %v", repo.Directory, fetchErr)
+		result.Failed = true
+		recordFailure(repo.Directory)
+		recordStatus(repo, result, 0, 0)
+		return result
+	}
+	glog.Infof("Fetch in %s finished", repo.Directory)
+	result.Fetched = true
+
+	ahead, behind, err := vcs.AheadBehind(ctx, repo)
+	if err != nil {
+		glog.Warningf("Ahead/behind count for %s unavailable: %v", repo.Directory, err)
 	}
-	return string(stderrBytes), nil
+
+	pullCtx, pullCancel := context.WithTimeout(ctx, flags.Timeout)
+	pullStart := time.Now()
+	skipped, pullErr := vcs.Pull(pullCtx, repo)
+	recordPhaseDuration("pull", time.Since(pullStart))
+	pullCancel()
+	if pullErr != nil {
+		glog.Errorf("Upgrade in %s failed: %v", repo.Directory, pullErr)
+		result.Failed = true
+		recordFailure(repo.Directory)
+	} else if skipped {
+		glog.Warningf("Upgrade in %s skipped", repo.Directory)
+		result.Skipped = true
+	} else {
+		glog.Infof("Upgrade in %s finished", repo.Directory)
+		result.Pulled = true
+	}
+	recordStatus(repo, result, ahead, behind)
+	return result
 }
 
 func upgrade() error {
@@ -145,53 +301,204 @@ func upgrade() error {
 		d := ed.Sub(st)
 		glog.Infof("Upgraded. Takes %.2f seconds.", d.Seconds())
 	}(time.Now())
-	// Check each repository
-	for _, repo := range config.Repos {
-		if stderr, err := gitClone(repo); err == filepath.SkipDir {
-			// No log for exist repos
-		} else if err != nil {
-			glog.Errorf("Clone to %s failed: %v\n%s", repo.Directory, err, stderr)
+
+	repos := filterRepos(config.Repos)
+
+	jobs := flags.Jobs
+	if jobs < 1 {
+		jobs = 1
+	}
+	ctx := context.Background()
+	repoCh := make(chan RepoConfig)
+	resultCh := make(chan upgradeSummary)
+
+	var wg sync.WaitGroup
+	wg.Add(jobs)
+	for i := 0; i < jobs; i++ {
+		go func() {
+			defer wg.Done()
+			for repo := range repoCh {
+				resultCh <- upgradeRepo(ctx, repo)
+			}
+		}()
+	}
+	go func() {
+		for _, repo := range repos {
+			repoCh <- repo
+		}
+		close(repoCh)
+	}()
+	go func() {
+		wg.Wait()
+		close(resultCh)
+	}()
+
+	results := make([]upgradeSummary, 0, len(repos))
+	for result := range resultCh {
+		results = append(results, result)
+	}
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Directory < results[j].Directory
+	})
+
+	var cloned, fetched, pulled, skipped, failed int
+	for _, result := range results {
+		if result.Cloned {
+			cloned++
+		}
+		if result.Fetched {
+			fetched++
+		}
+		if result.Pulled {
+			pulled++
+		}
+		if result.Skipped {
+			skipped++
+		}
+		if result.Failed {
+			failed++
+		}
+	}
+	glog.Infof("Summary: %d repos, %d cloned, %d fetched, %d pulled, %d skipped, %d failed",
+		len(results), cloned, fetched, pulled, skipped, failed)
+	return nil
+}
+
+// mirror pushes every repository with a Mirror remote configured to that
+// remote, after re-fetching it. Repos without a Mirror are left alone, a
+// repo with no commits newer than its MirrorLastSuccess is skipped instead
+// of re-pushed, and a failed push leaves MirrorLastSuccess untouched so
+// it's retried on the next run.
+func mirror() error {
+	glog.Info("Mirroring")
+	defer func(st time.Time) {
+		ed := time.Now()
+		d := ed.Sub(st)
+		glog.Infof("Mirrored. Takes %.2f seconds.", d.Seconds())
+	}(time.Now())
+	for i := range config.Repos {
+		repo := &config.Repos[i]
+		if repo.Mirror == "" {
 			continue
-		} else {
-			glog.Infof("Clone to %s finished", repo.Directory)
 		}
-		if stderr, err := gitFetch(repo); err != nil {
-			glog.Errorf("Fetch in %s failed: %v\n%s", repo.Directory, err, stderr)
+		fetchCtx, fetchCancel := context.WithTimeout(context.Background(), flags.Timeout)
+		err := vcs.Fetch(fetchCtx, *repo)
+		fetchCancel()
+		if err != nil {
+			glog.Errorf("Fetch in %s failed: %v", repo.Directory, err)
 			continue
-		} else {
-			glog.Infof("Fetch in %s finished", repo.Directory)
 		}
-		if stderr, err := gitPull(repo); err == filepath.SkipDir {
-			glog.Warningf("Upgrade in %s skipped", repo.Directory)
-		} else if err != nil {
-			glog.Errorf("Upgrade in %s failed: %v\n%s", repo.Directory, err, stderr)
+		changedCtx, changedCancel := context.WithTimeout(context.Background(), flags.Timeout)
+		changed, err := vcs.Changed(changedCtx, *repo, repo.MirrorLastSuccess)
+		changedCancel()
+		if err != nil {
+			glog.Warningf("Change check for %s unavailable, mirroring anyway: %v", repo.Directory, err)
+		} else if !changed {
+			glog.Infof("Mirror of %s skipped, nothing new since last mirror", repo.Directory)
+			continue
+		}
+		mirrorCtx, mirrorCancel := context.WithTimeout(context.Background(), flags.Timeout)
+		err = vcs.Mirror(mirrorCtx, *repo)
+		mirrorCancel()
+		if err != nil {
+			glog.Errorf("Mirror of %s to %s failed: %v", repo.Directory, repo.Mirror, err)
 			continue
-		} else {
-			glog.Infof("Upgrade in %s finished", repo.Directory)
 		}
+		repo.MirrorLastSuccess = time.Now()
+		glog.Infof("Mirror of %s finished", repo.Directory)
 	}
 	return nil
 }
 
-func remoteDir(path string) (string, error) {
+// shouldIgnoreDir reports whether path should be skipped while walking
+// RootDir, per Config.Ignore and the -exclude flag. Patterns are matched
+// against both the full path and its base name.
+func shouldIgnoreDir(path string) bool {
+	patterns := config.Ignore
+	if flags.Exclude != "" {
+		patterns = append(patterns, flags.Exclude)
+	}
+	for _, pattern := range patterns {
+		if ok, _ := filepath.Match(pattern, path); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, filepath.Base(path)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// repoAllowed reports whether directory passes the -only filter: true if
+// -only is unset, or if some pattern in one of its comma-separated
+// Config.Groups matches directory. Shared by filterRepos and update()'s
+// walk so -only restricts discovery the same way it restricts upgrade().
+func repoAllowed(directory string) bool {
+	if flags.Only == "" {
+		return true
+	}
+	for _, group := range strings.Split(flags.Only, ",") {
+		for _, pattern := range config.Groups[group] {
+			if ok, _ := filepath.Match(pattern, directory); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// filterRepos narrows repos to the -only groups (if set) and drops any
+// whose directory matches -exclude, by full path or base name as
+// shouldIgnoreDir matches it. Only the repository's own directory is
+// checked, not its parents.
+func filterRepos(repos []RepoConfig) []RepoConfig {
+	filtered := make([]RepoConfig, 0, len(repos))
+	for _, repo := range repos {
+		if !repoAllowed(repo.Directory) {
+			continue
+		}
+		if flags.Exclude != "" {
+			if ok, _ := filepath.Match(flags.Exclude, repo.Directory); ok {
+				continue
+			}
+			if ok, _ := filepath.Match(flags.Exclude, filepath.Base(repo.Directory)); ok {
+				continue
+			}
+		}
+		filtered = append(filtered, repo)
+	}
+	return filtered
+}
+
+// remoteDir returns every `remote "name"` section found in path/config,
+// keyed by remote name, instead of only looking at "origin".
+func remoteDir(path string) (map[string]string, error) {
 	gitConfigFile, err := os.Open(filepath.Join(path, "config"))
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer gitConfigFile.Close()
 	gitConfig, err := ini.Load(gitConfigFile)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	section := gitConfig.Section("remote \"origin\"")
-	if section == nil {
-		return "", errors.New(fmt.Sprintf("can't find \"origin\" in %s", path))
+	remotes := map[string]string{}
+	for _, section := range gitConfig.Sections() {
+		name := section.Name()
+		if !strings.HasPrefix(name, `remote "`) || !strings.HasSuffix(name, `"`) {
+			continue
+		}
+		key := section.Key("url")
+		if key == nil {
+			continue
+		}
+		remoteName := strings.TrimSuffix(strings.TrimPrefix(name, `remote "`), `"`)
+		remotes[remoteName] = key.Value()
 	}
-	key := section.Key("url")
-	if key == nil {
-		return "", errors.New(fmt.Sprintf("can't find \"origin.url\" in %s", path))
+	if len(remotes) == 0 {
+		return nil, errors.New(fmt.Sprintf("can't find any remotes in %s", path))
 	}
-	return key.Value(), nil
+	return remotes, nil
 }
 
 func update() error {
@@ -208,30 +515,53 @@ func update() error {
 			glog.Error("Error in file path walk: ", err)
 			return err
 		}
-		if !info.IsDir() || info.Name() != ".git" {
+		if !info.IsDir() {
 			return nil
 		}
-		remote, err := remoteDir(path)
+		if info.Name() != ".git" && shouldIgnoreDir(path) {
+			return filepath.SkipDir
+		}
+		if info.Name() != ".git" {
+			return nil
+		}
+		directory := filepath.Dir(path)
+		if !repoAllowed(directory) {
+			return filepath.SkipDir
+		}
+		remotes, err := vcs.Remotes(path)
 		if err != nil {
 			return err
 		}
 		config.Repos = append(config.Repos, RepoConfig{
-			Directory: filepath.Dir(path),
-			Remote:    remote,
+			Directory: directory,
+			Remote:    remotes["origin"],
+			Remotes:   remotes,
 		})
 		return filepath.SkipDir
 	})
 	if err != nil {
 		return err
 	}
-	// Merge repositories
-	repoDict := map[string]string{}
+	// Merge repositories, keyed by directory. Later entries (freshly
+	// discovered by the walk above) win on Remote/Remotes, but a Branch or
+	// Mirror set by hand in an earlier entry, and its MirrorLastSuccess,
+	// are preserved.
+	repoDict := map[string]RepoConfig{}
 	for _, repo := range config.Repos {
-		repoDict[repo.Directory] = repo.Remote
+		if existing, ok := repoDict[repo.Directory]; ok {
+			if repo.Branch == "" {
+				repo.Branch = existing.Branch
+			}
+			if repo.Mirror == "" {
+				repo.Mirror = existing.Mirror
+				repo.MirrorLastSuccess = existing.MirrorLastSuccess
+			}
+		}
+		repoDict[repo.Directory] = repo
 	}
 	config.Repos = make([]RepoConfig, 0, len(repoDict))
-	for dir, remote := range repoDict {
-		config.Repos = append(config.Repos, RepoConfig{dir, remote})
+	for _, repo := range repoDict {
+		config.Repos = append(config.Repos, repo)
 	}
 	sort.Slice(config.Repos, func(i, j int) bool {
 		return config.Repos[i].Directory < config.Repos[j].Directory
@@ -262,6 +592,12 @@ func do() error {
 			return err
 		}
 	}
+	// Mirror repositories
+	if flags.Mirror {
+		if err := mirror(); err != nil {
+			return err
+		}
+	}
 	// Update config file
 	if flags.Update {
 		configYAML, err := yaml.Marshal(config)
@@ -278,8 +614,28 @@ func do() error {
 
 func main() {
 	flag.Parse()
-	glog.Info("Recursively check code in directory ", flags.RootDir)
-	if err := do(); err != nil {
+	v, err := newVCS(flags.Backend)
+	if err != nil {
 		glog.Error(err)
+		os.Exit(1)
+	}
+	vcs = v
+	glog.Info("Recursively check code in directory ", flags.RootDir)
+	if flags.HTTP == "" {
+		if err := do(); err != nil {
+			glog.Error(err)
+		}
+		return
+	}
+	// Daemon mode: serve the status/metrics server and keep re-running
+	// update/upgrade/mirror on -interval instead of exiting after one pass.
+	startHTTPServer(flags.HTTP)
+	ticker := time.NewTicker(flags.Interval)
+	defer ticker.Stop()
+	for {
+		if err := do(); err != nil {
+			glog.Error(err)
+		}
+		<-ticker.C
 	}
 }
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jinzhao1994/glog"
+)
+
+// repoStatus is the latest known state of one repository, as observed by
+// the upgrade worker pool. It backs both the "/" status page and the
+// "/metrics" endpoint.
+type repoStatus struct {
+	Directory  string
+	Remote     string
+	LastFetch  time.Time
+	LastResult string
+	Ahead      int
+	Behind     int
+}
+
+var (
+	statusMu sync.RWMutex
+	statuses = map[string]*repoStatus{}
+
+	metricsMu      sync.Mutex
+	phaseDurations = map[string]float64{}
+	repoFailures   = map[string]int{}
+)
+
+// recordStatus updates the status snapshot for repo after an upgrade
+// attempt, classifying the outcome from its upgradeSummary.
+func recordStatus(repo RepoConfig, result upgradeSummary, ahead, behind int) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	s, ok := statuses[repo.Directory]
+	if !ok {
+		s = &repoStatus{Directory: repo.Directory}
+		statuses[repo.Directory] = s
+	}
+	s.Remote = repo.Remote
+	s.Ahead = ahead
+	s.Behind = behind
+	if result.Fetched {
+		s.LastFetch = time.Now()
+	}
+	switch {
+	case result.Failed:
+		s.LastResult = "failed"
+	case result.Pulled:
+		s.LastResult = "pulled"
+	case result.Skipped:
+		s.LastResult = "skipped"
+	case result.Cloned:
+		s.LastResult = "cloned"
+	}
+}
+
+func recordPhaseDuration(phase string, d time.Duration) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	phaseDurations[phase] += d.Seconds()
+}
+
+func recordFailure(directory string) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	repoFailures[directory]++
+}
+
+var indexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html>
+<head><title>code-manager</title></head>
+<body>
+<h1>code-manager</h1>
+<table border="1" cellpadding="4" cellspacing="0">
+<tr>
+<th><a href="?sort=directory">Directory</a></th>
+<th><a href="?sort=remote">Remote</a></th>
+<th><a href="?sort=lastfetch">Last Fetch</a></th>
+<th><a href="?sort=lastresult">Last Result</a></th>
+<th><a href="?sort=ahead">Ahead</a></th>
+<th><a href="?sort=behind">Behind</a></th>
+</tr>
+{{range .}}<tr><td>{{.Directory}}</td><td>{{.Remote}}</td><td>{{.LastFetch}}</td><td>{{.LastResult}}</td><td>{{.Ahead}}</td><td>{{.Behind}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// sortStatuses orders list in place by the column named in sortBy,
+// defaulting to Directory for an empty or unrecognized value.
+func sortStatuses(list []*repoStatus, sortBy string) {
+	switch sortBy {
+	case "remote":
+		sort.Slice(list, func(i, j int) bool { return list[i].Remote < list[j].Remote })
+	case "lastfetch":
+		sort.Slice(list, func(i, j int) bool { return list[i].LastFetch.Before(list[j].LastFetch) })
+	case "lastresult":
+		sort.Slice(list, func(i, j int) bool { return list[i].LastResult < list[j].LastResult })
+	case "ahead":
+		sort.Slice(list, func(i, j int) bool { return list[i].Ahead < list[j].Ahead })
+	case "behind":
+		sort.Slice(list, func(i, j int) bool { return list[i].Behind < list[j].Behind })
+	default:
+		sort.Slice(list, func(i, j int) bool { return list[i].Directory < list[j].Directory })
+	}
+}
+
+// handleIndex serves the status table, sorted by the column named in the
+// "sort" query parameter (directory/remote/lastfetch/lastresult/ahead/behind),
+// defaulting to directory. Column headers link to the other sort orders.
+func handleIndex(w http.ResponseWriter, r *http.Request) {
+	statusMu.RLock()
+	list := make([]*repoStatus, 0, len(statuses))
+	for _, s := range statuses {
+		list = append(list, s)
+	}
+	statusMu.RUnlock()
+	sortStatuses(list, r.URL.Query().Get("sort"))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := indexTemplate.Execute(w, list); err != nil {
+		glog.Error(err)
+	}
+}
+
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	statusMu.RLock()
+	repoCount := len(statuses)
+	statusMu.RUnlock()
+
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprintln(w, "# HELP code_manager_repos_total Number of repositories tracked.")
+	fmt.Fprintln(w, "# TYPE code_manager_repos_total gauge")
+	fmt.Fprintf(w, "code_manager_repos_total %d\n", repoCount)
+
+	fmt.Fprintln(w, "# HELP code_manager_upgrade_duration_seconds Cumulative time spent per upgrade phase.")
+	fmt.Fprintln(w, "# TYPE code_manager_upgrade_duration_seconds gauge")
+	for _, phase := range []string{"clone", "fetch", "pull"} {
+		fmt.Fprintf(w, "code_manager_upgrade_duration_seconds{phase=%q} %f\n", phase, phaseDurations[phase])
+	}
+
+	fmt.Fprintln(w, "# HELP code_manager_upgrade_failures_total Number of failed upgrade attempts per repository.")
+	fmt.Fprintln(w, "# TYPE code_manager_upgrade_failures_total counter")
+	directories := make([]string, 0, len(repoFailures))
+	for directory := range repoFailures {
+		directories = append(directories, directory)
+	}
+	sort.Strings(directories)
+	for _, directory := range directories {
+		fmt.Fprintf(w, "code_manager_upgrade_failures_total{repo=%q} %d\n", directory, repoFailures[directory])
+	}
+}
+
+// startHTTPServer serves the status page and Prometheus metrics on addr.
+// It runs in the background; failures are logged, not returned, since the
+// caller keeps running the upgrade loop regardless.
+func startHTTPServer(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", handleIndex)
+	mux.HandleFunc("/healthz", handleHealthz)
+	mux.HandleFunc("/metrics", handleMetrics)
+	go func() {
+		glog.Infof("Serving HTTP on %s", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			glog.Error(err)
+		}
+	}()
+}
@@ -0,0 +1,458 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	git "github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/go-git/go-git/v5/plumbing/transport/ssh"
+)
+
+// VCS abstracts the operations upgrade() and update() need from a version
+// control system, so the tool no longer hard-depends on a `git` binary
+// being present on PATH.
+type VCS interface {
+	Clone(ctx context.Context, repo RepoConfig) error
+	Fetch(ctx context.Context, repo RepoConfig) error
+	Pull(ctx context.Context, repo RepoConfig) (skipped bool, err error)
+	Mirror(ctx context.Context, repo RepoConfig) error
+	AheadBehind(ctx context.Context, repo RepoConfig) (ahead, behind int, err error)
+	// Changed reports whether repo has any commit newer than since, so
+	// mirror() can skip pushing a repository that hasn't moved since its
+	// last successful mirror. A zero since always counts as changed.
+	Changed(ctx context.Context, repo RepoConfig, since time.Time) (bool, error)
+	Remotes(dir string) (map[string]string, error)
+}
+
+// execVCS shells out to the git binary. This is the original implementation
+// and remains the default, since it matches whatever git the user already
+// has configured (credential helpers, hooks, etc).
+type execVCS struct{}
+
+func (execVCS) Clone(ctx context.Context, repo RepoConfig) error {
+	stderr, err := gitClone(ctx, repo)
+	if err == filepath.SkipDir {
+		return filepath.SkipDir
+	}
+	if err != nil {
+		return fmt.Errorf("%v\n%s", err, stderr)
+	}
+	return nil
+}
+
+func (execVCS) Fetch(ctx context.Context, repo RepoConfig) error {
+	stderr, err := gitFetch(ctx, repo)
+	if err != nil {
+		return fmt.Errorf("%v\n%s", err, stderr)
+	}
+	return nil
+}
+
+func (execVCS) Pull(ctx context.Context, repo RepoConfig) (bool, error) {
+	stderr, err := gitPull(ctx, repo)
+	if err == filepath.SkipDir {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("%v\n%s", err, stderr)
+	}
+	return false, nil
+}
+
+// Mirror runs `git push --mirror`. Authentication is whatever the git
+// binary itself is configured to use (credential helpers, ~/.netrc via
+// curl, ssh-agent via the inherited SSH_AUTH_SOCK, or a Config.Auth
+// SSH key via gitRunner), so no extra wiring is needed here.
+func (execVCS) Mirror(ctx context.Context, repo RepoConfig) error {
+	_, stderr, err := gitRunner(repo.Mirror).Run(ctx, repo.Directory, "push", "--mirror", repo.Mirror)
+	if err != nil {
+		return fmt.Errorf("%v\n%s", err, stderr)
+	}
+	return nil
+}
+
+// AheadBehind reports how many commits HEAD is ahead/behind its upstream.
+func (execVCS) AheadBehind(ctx context.Context, repo RepoConfig) (int, int, error) {
+	upstream, err := gitOutput(ctx, repo.Directory, "rev-parse", "--abbrev-ref", "@{u}")
+	if err != nil {
+		return 0, 0, err
+	}
+	return aheadBehind(ctx, repo.Directory, upstream)
+}
+
+// Changed runs `git log --all --since=<since> -1`; any output means some
+// ref moved after since.
+func (execVCS) Changed(ctx context.Context, repo RepoConfig, since time.Time) (bool, error) {
+	if since.IsZero() {
+		return true, nil
+	}
+	out, err := gitOutput(ctx, repo.Directory, "log", "--all", "-1", "--since="+since.Format(time.RFC3339), "--format=%H")
+	if err != nil {
+		return false, err
+	}
+	return out != "", nil
+}
+
+func (execVCS) Remotes(dir string) (map[string]string, error) {
+	return remoteDir(dir)
+}
+
+// goGitVCS implements VCS on top of github.com/go-git/go-git/v5, so the
+// tool keeps working on machines without a git binary installed.
+type goGitVCS struct{}
+
+func (goGitVCS) Clone(ctx context.Context, repo RepoConfig) error {
+	if _, err := os.Stat(filepath.Join(repo.Directory, ".git")); err == nil {
+		return filepath.SkipDir
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	auth, err := resolveAuth(repo.Remote)
+	if err != nil {
+		return err
+	}
+	_, err = git.PlainCloneContext(ctx, repo.Directory, false, &git.CloneOptions{
+		URL:  repo.Remote,
+		Auth: auth,
+	})
+	return err
+}
+
+func (goGitVCS) Fetch(ctx context.Context, repo RepoConfig) error {
+	r, err := git.PlainOpen(repo.Directory)
+	if err != nil {
+		return err
+	}
+	remoteName := trackingRemote(r)
+	auth, err := resolveAuth(urlForRemote(repo, remoteName))
+	if err != nil {
+		return err
+	}
+	if err := r.FetchContext(ctx, &git.FetchOptions{RemoteName: remoteName, Auth: auth}); err != nil && err != git.NoErrAlreadyUpToDate {
+		return err
+	}
+	return nil
+}
+
+func (goGitVCS) Pull(ctx context.Context, repo RepoConfig) (bool, error) {
+	r, err := git.PlainOpen(repo.Directory)
+	if err != nil {
+		return false, err
+	}
+	wt, err := r.Worktree()
+	if err != nil {
+		return false, err
+	}
+	status, err := wt.Status()
+	if err != nil {
+		return false, err
+	}
+	if !status.IsClean() {
+		return true, nil
+	}
+	head, err := r.Head()
+	if err != nil {
+		return false, err
+	}
+	if repo.Branch != "" && head.Name() != plumbing.NewBranchReferenceName(repo.Branch) {
+		return true, nil
+	}
+	remoteName := trackingRemote(r)
+	auth, err := resolveAuth(urlForRemote(repo, remoteName))
+	if err != nil {
+		return false, err
+	}
+	err = wt.PullContext(ctx, &git.PullOptions{RemoteName: remoteName, Auth: auth})
+	if err == git.NoErrAlreadyUpToDate {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// Mirror pushes every ref to repo.Mirror with Force set, mimicking
+// `git push --mirror`. repo.Mirror is pushed to directly rather than
+// through a named remote, since it's a one-off destination.
+func (goGitVCS) Mirror(ctx context.Context, repo RepoConfig) error {
+	r, err := git.PlainOpen(repo.Directory)
+	if err != nil {
+		return err
+	}
+	mirrorRemote := git.NewRemote(r.Storer, &gitconfig.RemoteConfig{
+		Name: "mirror",
+		URLs: []string{repo.Mirror},
+	})
+	auth, err := resolveAuth(repo.Mirror)
+	if err != nil {
+		return err
+	}
+	err = mirrorRemote.PushContext(ctx, &git.PushOptions{
+		RefSpecs: []gitconfig.RefSpec{"refs/*:refs/*"},
+		Force:    true,
+		Auth:     auth,
+	})
+	if err == git.NoErrAlreadyUpToDate {
+		return nil
+	}
+	return err
+}
+
+// trackingRemote resolves the git remote r's current branch is configured
+// to track (branch.<name>.remote), falling back to "origin" so behavior
+// matches plain `git fetch`/`git pull` when no per-branch remote is set or
+// HEAD is detached.
+func trackingRemote(r *git.Repository) string {
+	head, err := r.Head()
+	if err != nil {
+		return "origin"
+	}
+	cfg, err := r.Config()
+	if err != nil {
+		return "origin"
+	}
+	if branch, ok := cfg.Branches[head.Name().Short()]; ok && branch.Remote != "" {
+		return branch.Remote
+	}
+	return "origin"
+}
+
+// urlForRemote looks up the URL configured for remoteName in repo.Remotes,
+// falling back to repo.Remote (its legacy origin-only URL) when
+// remoteName isn't present there.
+func urlForRemote(repo RepoConfig, remoteName string) string {
+	if url, ok := repo.Remotes[remoteName]; ok {
+		return url
+	}
+	return repo.Remote
+}
+
+// resolveAuth picks credentials for remoteURL: the Config.Auth entry whose
+// URLPrefix is the longest match wins (so a specific prefix overrides a
+// blanket one regardless of declaration order), falling back to
+// defaultAuth's environment-based discovery when none match.
+func resolveAuth(remoteURL string) (transport.AuthMethod, error) {
+	var best *AuthConfig
+	for i := range config.Auth {
+		a := &config.Auth[i]
+		if !strings.HasPrefix(remoteURL, a.URLPrefix) {
+			continue
+		}
+		if best == nil || len(a.URLPrefix) > len(best.URLPrefix) {
+			best = a
+		}
+	}
+	if best == nil {
+		return defaultAuth(remoteURL)
+	}
+	switch best.Type {
+	case "ssh":
+		if best.KeyFile == "" {
+			return defaultAuth(remoteURL)
+		}
+		return ssh.NewPublicKeysFromFile("git", best.KeyFile, "")
+	case "https":
+		return defaultAuth(remoteURL)
+	case "token":
+		token := os.Getenv(best.TokenEnv)
+		if token == "" {
+			return nil, fmt.Errorf("auth for %s: env var %s is not set", remoteURL, best.TokenEnv)
+		}
+		return &http.BasicAuth{Username: token}, nil
+	default:
+		return nil, fmt.Errorf("unknown auth type %q for prefix %q", best.Type, best.URLPrefix)
+	}
+}
+
+// defaultAuth resolves credentials with no Config.Auth entry configured:
+// an ssh-agent when SSH_AUTH_SOCK is set and the remote is ssh, or a
+// login/password pulled from ~/.netrc for http(s) remotes. Returns a nil
+// AuthMethod (meaning "use go-git's defaults") when neither applies.
+func defaultAuth(remoteURL string) (transport.AuthMethod, error) {
+	ep, err := transport.NewEndpoint(remoteURL)
+	if err != nil {
+		return nil, err
+	}
+	switch ep.Protocol {
+	case "ssh":
+		if os.Getenv("SSH_AUTH_SOCK") == "" {
+			return nil, nil
+		}
+		return ssh.NewSSHAgentAuth(ep.User)
+	case "http", "https":
+		if login, password, ok := readNetrc(ep.Host); ok {
+			return &http.BasicAuth{Username: login, Password: password}, nil
+		}
+	}
+	return nil, nil
+}
+
+// readNetrc looks up a "machine <host>" entry in ~/.netrc.
+func readNetrc(host string) (login, password string, ok bool) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", "", false
+	}
+	data, err := ioutil.ReadFile(filepath.Join(home, ".netrc"))
+	if err != nil {
+		return "", "", false
+	}
+	fields := strings.Fields(string(data))
+	matched := false
+	for i, field := range fields {
+		switch field {
+		case "machine":
+			matched = i+1 < len(fields) && fields[i+1] == host
+		case "login":
+			if matched && i+1 < len(fields) {
+				login = fields[i+1]
+			}
+		case "password":
+			if matched && i+1 < len(fields) {
+				password = fields[i+1]
+			}
+		}
+	}
+	return login, password, login != "" && password != ""
+}
+
+// AheadBehind reports how many commits HEAD is ahead/behind the remote
+// tracking branch for HEAD's name under whichever remote it tracks.
+func (goGitVCS) AheadBehind(ctx context.Context, repo RepoConfig) (int, int, error) {
+	r, err := git.PlainOpen(repo.Directory)
+	if err != nil {
+		return 0, 0, err
+	}
+	head, err := r.Head()
+	if err != nil {
+		return 0, 0, err
+	}
+	upstreamRef, err := r.Reference(plumbing.NewRemoteReferenceName(trackingRemote(r), head.Name().Short()), true)
+	if err != nil {
+		return 0, 0, err
+	}
+	ahead, err := countCommitsNotIn(r, head.Hash(), upstreamRef.Hash())
+	if err != nil {
+		return 0, 0, err
+	}
+	behind, err := countCommitsNotIn(r, upstreamRef.Hash(), head.Hash())
+	if err != nil {
+		return 0, 0, err
+	}
+	return ahead, behind, nil
+}
+
+// countCommitsNotIn counts commits reachable from "from" that aren't
+// reachable from "excluding". It walks all of from's history, so commits
+// on every parent of a merge are counted.
+func countCommitsNotIn(r *git.Repository, from, excluding plumbing.Hash) (int, error) {
+	excludeIter, err := r.Log(&git.LogOptions{From: excluding})
+	if err != nil {
+		return 0, err
+	}
+	excludeSet := map[plumbing.Hash]bool{}
+	err = excludeIter.ForEach(func(c *object.Commit) error {
+		excludeSet[c.Hash] = true
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	fromIter, err := r.Log(&git.LogOptions{From: from})
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	err = fromIter.ForEach(func(c *object.Commit) error {
+		if !excludeSet[c.Hash] {
+			count++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// Changed walks every ref in repo and reports whether any commit it points
+// at was committed after since.
+func (goGitVCS) Changed(ctx context.Context, repo RepoConfig, since time.Time) (bool, error) {
+	if since.IsZero() {
+		return true, nil
+	}
+	r, err := git.PlainOpen(repo.Directory)
+	if err != nil {
+		return false, err
+	}
+	refs, err := r.References()
+	if err != nil {
+		return false, err
+	}
+	changed := false
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference {
+			return nil
+		}
+		commit, err := r.CommitObject(ref.Hash())
+		if err != nil {
+			// Not a commit (e.g. an annotated tag object); nothing to compare.
+			return nil
+		}
+		if commit.Committer.When.After(since) {
+			changed = true
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return changed, nil
+}
+
+func (goGitVCS) Remotes(dir string) (map[string]string, error) {
+	r, err := git.PlainOpen(filepath.Dir(dir))
+	if err != nil {
+		return nil, err
+	}
+	remotes, err := r.Remotes()
+	if err != nil {
+		return nil, err
+	}
+	urls := map[string]string{}
+	for _, remote := range remotes {
+		cfg := remote.Config()
+		if len(cfg.URLs) == 0 {
+			continue
+		}
+		urls[cfg.Name] = cfg.URLs[0]
+	}
+	if len(urls) == 0 {
+		return nil, errors.New("no remotes configured")
+	}
+	return urls, nil
+}
+
+// newVCS selects a VCS implementation based on the -backend flag.
+func newVCS(backend string) (VCS, error) {
+	switch backend {
+	case "", "git":
+		return execVCS{}, nil
+	case "go-git":
+		return goGitVCS{}, nil
+	default:
+		return nil, fmt.Errorf("unknown backend %q, want \"git\" or \"go-git\"", backend)
+	}
+}